@@ -0,0 +1,59 @@
+package handshake
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// AcceptHandshake handles an inbound peer connection: it reads the peer's
+// handshake first, uses lookup to find the local Handshake serving the
+// advertised info hash (a multi-torrent client serves many), and replies
+// with it — mirroring how real BitTorrent peers accept inbound
+// connections on their listening port.
+//
+// If lookup returns an error, the connection is closed without a reply
+// instead of sending back a rejection, so a peer scanning for info hashes
+// we serve can't distinguish "wrong info hash" from "slow peer" by
+// response shape.
+func AcceptHandshake(conn net.Conn, lookup func(infoHash []byte) (*Handshake, error)) (*Handshake, error) {
+	conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+
+	received := make([]byte, HANDSHAKE_LENGTH)
+	if _, err := io.ReadFull(conn, received); err != nil {
+		return nil, wrapReadError(err)
+	}
+
+	peer, err := DecodeHandshake(received)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := lookup(peer.InfoHash)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("rejecting peer %s: %w", conn.RemoteAddr(), err)
+	}
+
+	local.logDebug("accepted incoming handshake", "peer", conn.RemoteAddr(),
+		"info_hash", fmt.Sprintf("%x", peer.InfoHash), "extensions", peer.pReserved)
+
+	reply := bytes.Buffer{}
+	reply.WriteByte(byte(local.pLength))
+	reply.WriteString(local.pStr)
+	reply.Write(local.pReserved[:])
+	reply.Write(local.InfoHash)
+	reply.Write(local.PeerID)
+
+	if _, err := conn.Write(reply.Bytes()); err != nil {
+		err = fmt.Errorf("failed to send handshake reply: %w", err)
+		local.fireOnHandshake(conn, peer, err)
+		return nil, err
+	}
+
+	local.fireOnHandshake(conn, peer, nil)
+
+	return peer, nil
+}
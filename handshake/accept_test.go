@@ -0,0 +1,125 @@
+package handshake
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func rawHandshakeBytes(t *testing.T, infoHash, peerID []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteByte(byte(PROTOCOL_LENGTH))
+	buf.WriteString(PROTOCOL_STRING)
+	buf.Write(make([]byte, RESERVED_LENGTH))
+	buf.Write(infoHash)
+	buf.Write(peerID)
+	return buf.Bytes()
+}
+
+// TestAcceptHandshakeRoutesByInfoHash verifies that a multi-torrent lookup
+// replies with the local Handshake matching the peer's advertised info
+// hash, not just whichever torrent happens to be first.
+func TestAcceptHandshakeRoutesByInfoHash(t *testing.T) {
+	infoHashA := bytes.Repeat([]byte{0x01}, HASH_LENGTH)
+	infoHashB := bytes.Repeat([]byte{0x02}, HASH_LENGTH)
+
+	localA, err := NewHandshake(infoHashA, bytes.Repeat([]byte{0xAA}, PEER_ID_LENGTH), nil)
+	if err != nil {
+		t.Fatalf("NewHandshake(A): %v", err)
+	}
+	localB, err := NewHandshake(infoHashB, bytes.Repeat([]byte{0xBB}, PEER_ID_LENGTH), nil)
+	if err != nil {
+		t.Fatalf("NewHandshake(B): %v", err)
+	}
+
+	lookup := func(infoHash []byte) (*Handshake, error) {
+		switch {
+		case bytes.Equal(infoHash, infoHashA):
+			return localA, nil
+		case bytes.Equal(infoHash, infoHashB):
+			return localB, nil
+		default:
+			return nil, fmt.Errorf("not serving info hash %x", infoHash)
+		}
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	peerRaw := rawHandshakeBytes(t, infoHashB, bytes.Repeat([]byte{0xCC}, PEER_ID_LENGTH))
+
+	type peerResult struct {
+		reply []byte
+		err   error
+	}
+	resultCh := make(chan peerResult, 1)
+	go func() {
+		if _, err := clientConn.Write(peerRaw); err != nil {
+			resultCh <- peerResult{nil, err}
+			return
+		}
+		reply := make([]byte, HANDSHAKE_LENGTH)
+		_, err := io.ReadFull(clientConn, reply)
+		resultCh <- peerResult{reply, err}
+	}()
+
+	peer, err := AcceptHandshake(serverConn, lookup)
+	if err != nil {
+		t.Fatalf("AcceptHandshake: %v", err)
+	}
+	if !bytes.Equal(peer.InfoHash, infoHashB) {
+		t.Errorf("peer.InfoHash = %x, want %x", peer.InfoHash, infoHashB)
+	}
+
+	res := <-resultCh
+	if res.err != nil {
+		t.Fatalf("peer side: %v", res.err)
+	}
+
+	reply, err := DecodeHandshake(res.reply)
+	if err != nil {
+		t.Fatalf("DecodeHandshake(reply): %v", err)
+	}
+	if !bytes.Equal(reply.PeerID, localB.PeerID) {
+		t.Errorf("reply peer id = %x, want torrent B's local peer id %x (routed to the wrong torrent)",
+			reply.PeerID, localB.PeerID)
+	}
+}
+
+// TestAcceptHandshakeRejectsUnknownInfoHashWithoutReply verifies that a
+// lookup rejection closes the connection without writing anything back,
+// so a peer probing for which info hashes we serve can't distinguish
+// "wrong info hash" from "slow peer" by response shape.
+func TestAcceptHandshakeRejectsUnknownInfoHashWithoutReply(t *testing.T) {
+	infoHash := bytes.Repeat([]byte{0x09}, HASH_LENGTH)
+	peerRaw := rawHandshakeBytes(t, infoHash, bytes.Repeat([]byte{0xCC}, PEER_ID_LENGTH))
+
+	lookup := func(infoHash []byte) (*Handshake, error) {
+		return nil, errors.New("not serving this torrent")
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := clientConn.Write(peerRaw)
+		writeErr <- err
+	}()
+
+	if _, err := AcceptHandshake(serverConn, lookup); err == nil {
+		t.Fatal("AcceptHandshake: expected error for rejected info hash, got nil")
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	var b [1]byte
+	if _, err := clientConn.Read(b[:]); err == nil {
+		t.Fatal("expected read on rejected connection to fail (no reply sent), got data")
+	}
+}
@@ -0,0 +1,44 @@
+package handshake
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Sentinel errors returned by this package. Callers should use errors.Is
+// to distinguish failure modes (e.g. blacklisting a peer for a bad info
+// hash vs. retrying after a timeout) rather than matching on error text.
+var (
+	// ErrInfoHashMismatch means the peer's handshake carried a different
+	// info hash than the one we sent — the peer isn't serving this
+	// torrent and should be blacklisted for it.
+	ErrInfoHashMismatch = errors.New("handshake: info hash mismatch")
+	// ErrBadProtocolString means the peer's handshake didn't identify
+	// itself as "BitTorrent protocol".
+	ErrBadProtocolString = errors.New("handshake: bad protocol string")
+	// ErrBadHandshakeLength means the peer sent a handshake that wasn't
+	// HANDSHAKE_LENGTH bytes.
+	ErrBadHandshakeLength = errors.New("handshake: bad handshake length")
+	// ErrShortRead means the connection was closed before a full
+	// handshake could be read.
+	ErrShortRead = errors.New("handshake: connection closed before full handshake was read")
+	// ErrHandshakeTimeout means the peer didn't respond within the
+	// handshake read deadline.
+	ErrHandshakeTimeout = errors.New("handshake: timed out waiting for peer")
+)
+
+// wrapReadError classifies an error from reading a peer's handshake into
+// one of the sentinel errors above, wrapping the original error with %w
+// so errors.Is/errors.As still see both.
+func wrapReadError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %w", ErrHandshakeTimeout, err)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %w", ErrShortRead, err)
+	}
+	return fmt.Errorf("handshake: failed to read: %w", err)
+}
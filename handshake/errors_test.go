@@ -0,0 +1,82 @@
+package handshake
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeTimeoutError struct{}
+
+func (fakeTimeoutError) Error() string   { return "fake timeout" }
+func (fakeTimeoutError) Timeout() bool   { return true }
+func (fakeTimeoutError) Temporary() bool { return true }
+
+func TestWrapReadErrorClassification(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"timeout", fakeTimeoutError{}, ErrHandshakeTimeout},
+		{"eof", io.EOF, ErrShortRead},
+		{"unexpected eof", io.ErrUnexpectedEOF, ErrShortRead},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := wrapReadError(c.err)
+			if !errors.Is(got, c.want) {
+				t.Errorf("wrapReadError(%v) = %v, want errors.Is match for %v", c.err, got, c.want)
+			}
+			if !errors.Is(got, c.err) {
+				t.Errorf("wrapReadError(%v) = %v, lost the original error from the chain", c.err, got)
+			}
+		})
+	}
+}
+
+func TestWrapReadErrorGenericErrorNotMisclassified(t *testing.T) {
+	original := errors.New("connection reset by peer")
+	got := wrapReadError(original)
+	if errors.Is(got, ErrHandshakeTimeout) || errors.Is(got, ErrShortRead) {
+		t.Errorf("wrapReadError(%v) = %v, misclassified a generic error", original, got)
+	}
+	if !errors.Is(got, original) {
+		t.Errorf("wrapReadError(%v) = %v, lost the original error from the chain", original, got)
+	}
+}
+
+// TestWrapReadErrorRealTimeout exercises the classification against an
+// actual net.Error timeout, not just a fake, for a deadline that has
+// already elapsed.
+func TestWrapReadErrorRealTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline: %v", err)
+	}
+
+	var buf [1]byte
+	_, readErr := conn.Read(buf[:])
+	if readErr == nil {
+		t.Fatal("expected a timeout error reading past an elapsed deadline")
+	}
+
+	wrapped := wrapReadError(readErr)
+	if !errors.Is(wrapped, ErrHandshakeTimeout) {
+		t.Errorf("wrapReadError(%v) = %v, want errors.Is(ErrHandshakeTimeout)", readErr, wrapped)
+	}
+}
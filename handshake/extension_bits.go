@@ -0,0 +1,49 @@
+package handshake
+
+import "strings"
+
+// Bit positions within the 8-byte reserved field, numbered per the wire
+// convention where bit N is the Nth most significant bit across the 8
+// bytes: reserved[N/8] |= 1 << (7 - N%8). These match the positions real
+// peers use: DHT is reserved[7] & 0x01, Fast Extension is reserved[7] &
+// 0x04, and LTEP is reserved[5] & 0x10.
+const (
+	ExtensionBitDHT      uint = 63 // BEP 5: DHT (reserved[7] & 0x01)
+	ExtensionBitFast     uint = 61 // BEP 6: Fast Extension (reserved[7] & 0x04)
+	ExtensionBitExtended uint = 43 // BEP 10: Extension Protocol (LTEP) (reserved[5] & 0x10)
+)
+
+// PeerExtensionBits is the 8-byte reserved field from a handshake, exposed
+// as a first-class type so callers can query or set individual extension
+// bits instead of poking at the raw bytes.
+type PeerExtensionBits [RESERVED_LENGTH]byte
+
+// SetBit enables the extension bit at position bit (bit 0 is the most
+// significant bit of the first reserved byte).
+func (b *PeerExtensionBits) SetBit(bit uint) {
+	b[bit/8] |= 1 << (7 - bit%8)
+}
+
+// GetBit reports whether the extension bit at position bit is set.
+func (b PeerExtensionBits) GetBit(bit uint) bool {
+	return b[bit/8]&(1<<(7-bit%8)) != 0
+}
+
+// String renders the enabled, named extension bits as a "|"-separated tag
+// list (e.g. "dht|fast|ext") for logging. Unnamed bits are omitted.
+func (b PeerExtensionBits) String() string {
+	var tags []string
+	if b.GetBit(ExtensionBitDHT) {
+		tags = append(tags, "dht")
+	}
+	if b.GetBit(ExtensionBitFast) {
+		tags = append(tags, "fast")
+	}
+	if b.GetBit(ExtensionBitExtended) {
+		tags = append(tags, "ext")
+	}
+	if len(tags) == 0 {
+		return "none"
+	}
+	return strings.Join(tags, "|")
+}
@@ -0,0 +1,79 @@
+package handshake
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExtensionBitPositions(t *testing.T) {
+	// The wire positions real peers use, independent of SetBit/GetBit:
+	// DHT is reserved[7] & 0x01, Fast is reserved[7] & 0x04, LTEP is
+	// reserved[5] & 0x10.
+	cases := []struct {
+		name      string
+		bit       uint
+		byteIndex int
+		mask      byte
+	}{
+		{"DHT", ExtensionBitDHT, 7, 0x01},
+		{"Fast", ExtensionBitFast, 7, 0x04},
+		{"Extended", ExtensionBitExtended, 5, 0x10},
+	}
+
+	for _, c := range cases {
+		var b PeerExtensionBits
+		b.SetBit(c.bit)
+		if b[c.byteIndex] != c.mask {
+			t.Errorf("%s: SetBit(%d) set reserved = %x, want byte %d == %#x",
+				c.name, c.bit, b, c.byteIndex, c.mask)
+		}
+	}
+}
+
+func TestPeerExtensionBitsSetGetRoundTrip(t *testing.T) {
+	var b PeerExtensionBits
+	b.SetBit(ExtensionBitDHT)
+	b.SetBit(ExtensionBitFast)
+	b.SetBit(ExtensionBitExtended)
+
+	if !b.GetBit(ExtensionBitDHT) || !b.GetBit(ExtensionBitFast) || !b.GetBit(ExtensionBitExtended) {
+		t.Fatalf("GetBit did not report set bits: %v", b)
+	}
+	if got, want := b.String(), "dht|fast|ext"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	var none PeerExtensionBits
+	if got, want := none.String(), "none"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// TestDecodeHandshakeRealCapturedReservedBytes decodes the reserved field
+// of a real captured handshake (µTorrent sets bits 20, 21, 47 and 63) and
+// checks which of the named extension bits it reports.
+func TestDecodeHandshakeRealCapturedReservedBytes(t *testing.T) {
+	reserved := []byte{0x00, 0x00, 0x0c, 0x00, 0x00, 0x01, 0x00, 0x01}
+
+	buf := bytes.Buffer{}
+	buf.WriteByte(PROTOCOL_LENGTH)
+	buf.WriteString(PROTOCOL_STRING)
+	buf.Write(reserved)
+	buf.Write(bytes.Repeat([]byte{0xAB}, HASH_LENGTH))
+	buf.WriteString("-UT3530-123456789012")
+
+	h, err := DecodeHandshake(buf.Bytes()[:HANDSHAKE_LENGTH])
+	if err != nil {
+		t.Fatalf("DecodeHandshake: %v", err)
+	}
+
+	if !h.ExtensionBits().GetBit(ExtensionBitDHT) {
+		t.Error("expected DHT bit (63) set, matching µTorrent's advertised reserved bytes")
+	}
+	if h.ExtensionBits().GetBit(ExtensionBitFast) {
+		t.Error("Fast bit (61) should not be set by this capture")
+	}
+	if h.ExtensionBits().GetBit(ExtensionBitExtended) {
+		t.Error("Extended/LTEP bit (43) should not be set by this capture")
+	}
+}
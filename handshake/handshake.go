@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"time"
 )
@@ -17,36 +18,80 @@ const (
 	PEER_ID_LENGTH   = 20
 )
 
+// OnHandshakeFunc is called after every handshake exchange attempt,
+// successful or not, so callers can feed connection metrics/UI without
+// this package importing them.
+type OnHandshakeFunc func(peer net.Addr, sent, received *Handshake, err error)
+
 // https://wiki.theory.org/BitTorrentSpecification#Handshake
 type Handshake struct {
 	InfoHash  []byte
 	PeerID    []byte
 	pLength   int
 	pStr      string
-	pReserved []byte
+	pReserved PeerExtensionBits
+
+	// Logger receives debug-level logging of each handshake exchange. A
+	// nil Logger disables logging; this is the default so debug output
+	// stays opt-in.
+	Logger *slog.Logger
+
+	// OnHandshake, if set, is notified after every handshake exchange.
+	OnHandshake OnHandshakeFunc
+}
+
+// HandshakeOptions controls which extension bits we advertise in the
+// reserved field before sending our handshake. A nil *HandshakeOptions is
+// equivalent to all bits unset, matching the previous all-zeros behavior.
+type HandshakeOptions struct {
+	DHT      bool // advertise ExtensionBitDHT (BEP 5)
+	Fast     bool // advertise ExtensionBitFast (BEP 6)
+	Extended bool // advertise ExtensionBitExtended (BEP 10)
 }
 
-func NewHandshake(infoHash, peerID []byte) (*Handshake, error) {
+func NewHandshake(infoHash, peerID []byte, opts *HandshakeOptions) (*Handshake, error) {
 	if len(infoHash) != HASH_LENGTH {
 		return nil, fmt.Errorf("info hash must be %d bytes, got %d", HASH_LENGTH, len(infoHash))
 	}
 
+	var reserved PeerExtensionBits
+	if opts != nil {
+		if opts.DHT {
+			reserved.SetBit(ExtensionBitDHT)
+		}
+		if opts.Fast {
+			reserved.SetBit(ExtensionBitFast)
+		}
+		if opts.Extended {
+			reserved.SetBit(ExtensionBitExtended)
+		}
+	}
+
 	return &Handshake{
 		pLength:   PROTOCOL_LENGTH,
 		pStr:      PROTOCOL_STRING,
-		pReserved: make([]byte, RESERVED_LENGTH), // All zeros
+		pReserved: reserved,
 		InfoHash:  infoHash,
 		PeerID:    peerID,
 	}, nil
 }
 
+// ExtensionBits returns the reserved field's extension bits, as sent (for a
+// handshake we built) or as advertised by the peer (for one we decoded).
+func (h *Handshake) ExtensionBits() PeerExtensionBits {
+	return h.pReserved
+}
+
+// ExchangeHandshake takes a Connection (to another peer) as an argument
+// and sends our handshake, then waits for the peer to respond with its
+// own handshake and returns the raw bytes.
 func (h *Handshake) ExchangeHandshake(connPeer net.Conn) ([]byte, error) {
 	hBuf := bytes.Buffer{}
 
 	// Build handshake: pstrlen + pstr + reserved + info_hash + peer_id
 	hBuf.WriteByte(byte(h.pLength)) // pstrlen (1 byte)
 	hBuf.WriteString(h.pStr)        // pstr (19 bytes)
-	hBuf.Write(h.pReserved)         // reserved (8 bytes) - should be all zeros
+	hBuf.Write(h.pReserved[:])      // reserved (8 bytes) - extension bits, see PeerExtensionBits
 	hBuf.Write(h.InfoHash)          // info_hash (20 bytes)
 	hBuf.Write(h.PeerID)            // peer_id (20 bytes)
 
@@ -57,19 +102,13 @@ func (h *Handshake) ExchangeHandshake(connPeer net.Conn) ([]byte, error) {
 			HANDSHAKE_LENGTH, len(hBytes))
 	}
 
-	// Proper debugging output
-	fmt.Printf("\n\n=== OUTGOING HANDSHAKE ===\n")
-	fmt.Printf("Total length: %d bytes\n", len(hBytes))
-	fmt.Printf("Protocol length: %d\n", hBytes[0])
-	fmt.Printf("Protocol string: %q\n", string(hBytes[1:20]))
-	fmt.Printf("Reserved field: %x (should be all zeros)\n", hBytes[20:28])
-	fmt.Printf("Info hash: %x\n", hBytes[28:48])
-	fmt.Printf("Peer ID: %q\n", string(hBytes[48:68]))
-	fmt.Printf("Full handshake: %s\n", hBytes)
-	fmt.Printf("========================\n")
+	h.logDebug("sending handshake", "peer", connPeer.RemoteAddr(),
+		"info_hash", fmt.Sprintf("%x", h.InfoHash), "extensions", h.pReserved)
 
 	if _, err := connPeer.Write(hBytes); err != nil {
-		return nil, fmt.Errorf("failed to send handshake: %v", err)
+		err = fmt.Errorf("failed to send handshake: %w", err)
+		h.fireOnHandshake(connPeer, nil, err)
+		return nil, err
 	}
 
 	// Set read timeout
@@ -77,116 +116,94 @@ func (h *Handshake) ExchangeHandshake(connPeer net.Conn) ([]byte, error) {
 
 	received := make([]byte, HANDSHAKE_LENGTH)
 	if _, err := io.ReadFull(connPeer, received); err != nil {
-		return nil, fmt.Errorf("failed to read handshake response: %v", err)
+		err = wrapReadError(err)
+		h.fireOnHandshake(connPeer, nil, err)
+		return nil, err
+	}
+
+	peer, err := DecodeHandshake(received)
+	if err != nil {
+		h.fireOnHandshake(connPeer, nil, err)
+		return nil, err
 	}
 
-	// Proper debugging for received handshake
-	fmt.Printf("\n\n=== INCOMING HANDSHAKE ===\n")
-	fmt.Printf("Total length: %d bytes\n", len(received))
-	fmt.Printf("Protocol length: %d\n", received[0])
-	fmt.Printf("Protocol string: %q\n", string(received[1:20]))
-	fmt.Printf("Reserved field: %x\n", received[20:28])
-	fmt.Printf("Info hash: %x\n", received[28:48])
-	fmt.Printf("Peer ID: %q\n", string(received[48:68]))
-	fmt.Printf("Full handshake: %s\n", received)
-	fmt.Printf("========================\n")
+	h.logDebug("received handshake", "peer", connPeer.RemoteAddr(),
+		"info_hash", fmt.Sprintf("%x", peer.InfoHash), "extensions", peer.pReserved)
+	h.fireOnHandshake(connPeer, peer, nil)
 
 	return received, nil
 }
 
-// Takes a Connection (to another peer) as an argument and sends our handshake.
-// Then waits for the peer to respond with its handshake and return it
-func (h *Handshake) ExchangeHandshakeOld(connPeer net.Conn) ([]byte, error) {
-	hBuf := bytes.Buffer{}
-
-	// Build handshake: pstrlen + pstr + reserved + info_hash + peer_id
-	hBuf.WriteByte(byte(h.pLength)) // pstrlen (1 byte)
-	hBuf.WriteString(h.pStr)        // pstr (19 bytes)
-	hBuf.Write(h.pReserved)         // reserved (8 bytes)
-	hBuf.Write(h.InfoHash)          // info_hash (20 bytes)
-	hBuf.Write(h.PeerID)            // peer_id (20 bytes)
-
-	hBytes := hBuf.Bytes() // 1 + 19 + 8 + 20 + 20 = 68 bytes
-
-	if len(hBytes) != HANDSHAKE_LENGTH {
-		return nil, fmt.Errorf("handshake byte length expected %d bytes, got: %d",
-			HANDSHAKE_LENGTH, len(hBytes))
-	}
-
-	fmt.Printf("Sending Handshake (%d bytes): %s\n", len(hBytes), hBytes)
-
-	if _, err := connPeer.Write(hBytes); err != nil {
-		return nil, fmt.Errorf("failed to send handshake: %v", err)
+// logDebug logs at debug level if a Logger is configured; it is a no-op
+// otherwise so debug output stays opt-in.
+func (h *Handshake) logDebug(msg string, args ...any) {
+	if h.Logger != nil {
+		h.Logger.Debug(msg, args...)
 	}
+}
 
-	// Set read timeout
-	connPeer.SetReadDeadline(time.Now().Add(time.Second * 10))
-
-	received := make([]byte, HANDSHAKE_LENGTH)
-	if _, err := io.ReadFull(connPeer, received); err != nil {
-		return nil, fmt.Errorf("failed to read handshake response: %v", err)
+// fireOnHandshake notifies OnHandshake, if set, of the outcome of a
+// handshake exchange with connPeer.
+func (h *Handshake) fireOnHandshake(connPeer net.Conn, received *Handshake, err error) {
+	if h.OnHandshake != nil {
+		h.OnHandshake(connPeer.RemoteAddr(), h, received, err)
 	}
-
-	fmt.Printf("Received Handshake (%d bytes): %s\n", len(received), received)
-
-	return received, nil
 }
 
 // Decode a Handshake sent by another Peer
 func DecodeHandshake(buf []byte) (*Handshake, error) {
 	if len(buf) != HANDSHAKE_LENGTH {
-		return nil, fmt.Errorf("invalid handshake length, expected %d bytes, got: %d",
-			HANDSHAKE_LENGTH, len(buf))
+		return nil, fmt.Errorf("%w: expected %d bytes, got: %d",
+			ErrBadHandshakeLength, HANDSHAKE_LENGTH, len(buf))
 	}
 
 	pLength := int(buf[0])
 	if pLength != PROTOCOL_LENGTH {
-		return nil, fmt.Errorf("invalid protocol length: expected %d, got %d",
-			PROTOCOL_LENGTH, pLength)
+		return nil, fmt.Errorf("%w: invalid protocol length: expected %d, got %d",
+			ErrBadProtocolString, PROTOCOL_LENGTH, pLength)
 	}
 
 	pStr := string(buf[1 : 1+pLength])
 	if pStr != PROTOCOL_STRING {
-		return nil, fmt.Errorf("invalid protocol string: expected %q, got %q",
-			PROTOCOL_STRING, pStr)
+		return nil, fmt.Errorf("%w: expected %q, got %q",
+			ErrBadProtocolString, PROTOCOL_STRING, pStr)
 	}
 
 	h := &Handshake{
-		pLength:   pLength,
-		pStr:      pStr,
-		pReserved: buf[20:28], // 8 bytes
-		InfoHash:  buf[28:48], // 20 bytes
-		PeerID:    buf[48:68], // 20 bytes
+		pLength:  pLength,
+		pStr:     pStr,
+		InfoHash: buf[28:48], // 20 bytes
+		PeerID:   buf[48:68], // 20 bytes
 	}
+	copy(h.pReserved[:], buf[20:28]) // 8 bytes
 
 	return h, nil
 }
 
-func (h *Handshake) VerifyHandshake(raw []byte) error {
+// VerifyHandshake decodes raw as a peer handshake, checks it against h
+// (protocol string and info hash), and returns the decoded peer handshake
+// so the caller can inspect the peer's advertised extension bits via
+// peer.ExtensionBits().
+func (h *Handshake) VerifyHandshake(raw []byte) (*Handshake, error) {
 	h2, err := DecodeHandshake(raw)
 	if err != nil {
-		return fmt.Errorf("failed to decode handshake: %v", err)
+		return nil, fmt.Errorf("failed to decode handshake: %w", err)
 	}
 
 	if h2.pLength != PROTOCOL_LENGTH {
-		return fmt.Errorf("protocol length mismatch: expected %d, got %d",
-			PROTOCOL_LENGTH, h2.pLength)
+		return nil, fmt.Errorf("%w: protocol length mismatch: expected %d, got %d",
+			ErrBadProtocolString, PROTOCOL_LENGTH, h2.pLength)
 	}
 
 	if h2.pStr != PROTOCOL_STRING {
-		return fmt.Errorf("protocol string mismatch: expected %q, got %q",
-			PROTOCOL_STRING, h2.pStr)
-	}
-
-	if len(h2.pReserved) != RESERVED_LENGTH {
-		return fmt.Errorf("reserved field length mismatch: expected %d, got %d",
-			RESERVED_LENGTH, len(h2.pReserved))
+		return nil, fmt.Errorf("%w: protocol string mismatch: expected %q, got %q",
+			ErrBadProtocolString, PROTOCOL_STRING, h2.pStr)
 	}
 
 	if !bytes.Equal(h.InfoHash, h2.InfoHash) {
-		return fmt.Errorf("info hash mismatch: expected %x, got %x",
-			h.InfoHash, h2.InfoHash)
+		return nil, fmt.Errorf("%w: expected %x, got %x",
+			ErrInfoHashMismatch, h.InfoHash, h2.InfoHash)
 	}
 
-	return nil
+	return h2, nil
 }
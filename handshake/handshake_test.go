@@ -0,0 +1,95 @@
+package handshake
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestExchangeHandshakeFiresOnHandshake verifies OnHandshake is notified
+// with the sent and decoded-received handshakes after a successful
+// exchange, and that a configured Logger doesn't interfere.
+func TestExchangeHandshakeFiresOnHandshake(t *testing.T) {
+	infoHash := bytes.Repeat([]byte{0x11}, HASH_LENGTH)
+	localPeerID := bytes.Repeat([]byte{0x22}, PEER_ID_LENGTH)
+	remotePeerID := bytes.Repeat([]byte{0x33}, PEER_ID_LENGTH)
+
+	h, err := NewHandshake(infoHash, localPeerID, nil)
+	if err != nil {
+		t.Fatalf("NewHandshake: %v", err)
+	}
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var gotSent, gotReceived *Handshake
+	var gotErr error
+	called := make(chan struct{}, 1)
+	h.OnHandshake = func(peer net.Addr, sent, received *Handshake, err error) {
+		gotSent, gotReceived, gotErr = sent, received, err
+		called <- struct{}{}
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	peerRaw := rawHandshakeBytes(t, infoHash, remotePeerID)
+	go func() {
+		buf := make([]byte, HANDSHAKE_LENGTH)
+		io.ReadFull(clientConn, buf) // consume our outgoing handshake
+		clientConn.Write(peerRaw)
+	}()
+
+	if _, err := h.ExchangeHandshake(serverConn); err != nil {
+		t.Fatalf("ExchangeHandshake: %v", err)
+	}
+
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("OnHandshake was not called")
+	}
+
+	if gotErr != nil {
+		t.Errorf("OnHandshake err = %v, want nil", gotErr)
+	}
+	if gotSent != h {
+		t.Errorf("OnHandshake sent = %p, want %p", gotSent, h)
+	}
+	if gotReceived == nil || !bytes.Equal(gotReceived.PeerID, remotePeerID) {
+		t.Errorf("OnHandshake received peer id = %v, want %x", gotReceived, remotePeerID)
+	}
+}
+
+// TestExchangeHandshakeFiresOnHandshakeOnError verifies OnHandshake is
+// still notified, with a non-nil error, when the exchange fails.
+func TestExchangeHandshakeFiresOnHandshakeOnError(t *testing.T) {
+	h, err := NewHandshake(bytes.Repeat([]byte{0x11}, HASH_LENGTH), bytes.Repeat([]byte{0x22}, PEER_ID_LENGTH), nil)
+	if err != nil {
+		t.Fatalf("NewHandshake: %v", err)
+	}
+
+	var gotErr error
+	called := make(chan struct{}, 1)
+	h.OnHandshake = func(peer net.Addr, sent, received *Handshake, err error) {
+		gotErr = err
+		called <- struct{}{}
+	}
+
+	serverConn, clientConn := net.Pipe()
+	clientConn.Close() // peer is gone before we can write to it
+
+	if _, err := h.ExchangeHandshake(serverConn); err == nil {
+		t.Fatal("expected an error writing to a closed peer")
+	}
+
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("OnHandshake was not called on failure")
+	}
+	if gotErr == nil {
+		t.Error("OnHandshake err = nil, want non-nil")
+	}
+}
@@ -0,0 +1,76 @@
+package mse
+
+import (
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"math/big"
+)
+
+// mseKeyLen is the length, in bytes, of the fixed 768-bit MSE prime and of
+// the Ya/Yb Diffie-Hellman public values derived from it.
+const mseKeyLen = 96
+
+// msePrime is the fixed 768-bit MSE prime, per the BitTorrent Message
+// Stream Encryption spec.
+var msePrime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563",
+	16,
+)
+
+var mseGenerator = big.NewInt(2)
+
+// dhKeyPair is one side's Diffie-Hellman private exponent and the public
+// value derived from it (Y = G^X mod P), padded to mseKeyLen bytes.
+type dhKeyPair struct {
+	private *big.Int
+	public  []byte
+}
+
+// newDHKeyPair generates a random 160-bit private exponent and the
+// corresponding public value.
+func newDHKeyPair() (*dhKeyPair, error) {
+	priv := make([]byte, 20) // 160 bits, per the MSE spec
+	if _, err := rand.Read(priv); err != nil {
+		return nil, err
+	}
+
+	x := new(big.Int).SetBytes(priv)
+	y := new(big.Int).Exp(mseGenerator, x, msePrime)
+
+	return &dhKeyPair{
+		private: x,
+		public:  y.FillBytes(make([]byte, mseKeyLen)),
+	}, nil
+}
+
+// sharedSecret computes S = peerPublic^private mod P, padded to mseKeyLen
+// bytes.
+func (kp *dhKeyPair) sharedSecret(peerPublic []byte) []byte {
+	y := new(big.Int).SetBytes(peerPublic)
+	s := new(big.Int).Exp(y, kp.private, msePrime)
+	return s.FillBytes(make([]byte, mseKeyLen))
+}
+
+// mseHash computes SHA1(label || parts...), matching the HASH(x, y, ...)
+// notation used throughout the MSE spec.
+func mseHash(label string, parts ...[]byte) []byte {
+	h := sha1.New()
+	h.Write([]byte(label))
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// newSyncedRC4 builds an RC4 cipher keyed as the spec requires and
+// discards the first 1024 bytes of keystream before returning it.
+func newSyncedRC4(key []byte) (*rc4.Cipher, error) {
+	c, err := rc4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	var discard [1024]byte
+	c.XORKeyStream(discard[:], discard[:])
+	return c, nil
+}
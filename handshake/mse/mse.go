@@ -0,0 +1,294 @@
+// Package mse implements the BitTorrent Message Stream Encryption /
+// Protocol Encryption handshake (https://wiki.vuze.com/w/Message_Stream_Encryption),
+// as a pluggable transport for outgoing peer connections. Some peers on
+// public trackers, and peers behind ISPs that throttle plaintext
+// BitTorrent, refuse connections that don't start with this handshake.
+package mse
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Mode selects how a Dialer negotiates encryption for outgoing
+// connections.
+type Mode int
+
+const (
+	// Plaintext dials the standard, unencrypted BitTorrent handshake.
+	Plaintext Mode = iota
+	// PreferEncrypted attempts the MSE handshake first and falls back to
+	// a fresh plaintext connection if the peer doesn't speak it.
+	PreferEncrypted
+	// ForceEncrypted only ever attempts the MSE handshake; the caller
+	// gets an error if the peer doesn't support it.
+	ForceEncrypted
+)
+
+// crypto_provide / crypto_select bitfield values (MSE spec section 2).
+const (
+	cryptoPlaintext uint32 = 1 << 0
+	cryptoRC4       uint32 = 1 << 1
+)
+
+// maxPadLen bounds PadA/PadC on send and the sync-search window on
+// receive; the spec allows 0-512 bytes of padding.
+const maxPadLen = 512
+
+// dialTimeout bounds the initial TCP connect; negotiateTimeout bounds the
+// whole MSE negotiation once connected, so a peer that accepts the
+// connection and then stalls can't hang a Dial forever.
+const (
+	dialTimeout      = 10 * time.Second
+	negotiateTimeout = 10 * time.Second
+)
+
+// DialError reports why an MSE dial attempt failed. Unreachable is true
+// when the TCP connection itself could not be established, where retrying
+// the other Mode won't help; it is false when the connection was made but
+// the MSE negotiation failed for any reason (including read/write errors
+// or a timeout during it), which is the case PreferEncrypted falls back on.
+type DialError struct {
+	Addr        string
+	Unreachable bool
+	Err         error
+}
+
+func (e *DialError) Error() string {
+	if e.Unreachable {
+		return fmt.Sprintf("mse: dial %s: unreachable: %v", e.Addr, e.Err)
+	}
+	return fmt.Sprintf("mse: dial %s: peer refused encrypted handshake: %v", e.Addr, e.Err)
+}
+
+func (e *DialError) Unwrap() error { return e.Err }
+
+// Dialer establishes outgoing peer connections, optionally negotiating
+// MSE/PE encryption first.
+type Dialer struct {
+	Mode Mode
+}
+
+// NewDialer returns a Dialer that negotiates encryption according to mode.
+func NewDialer(mode Mode) *Dialer {
+	return &Dialer{Mode: mode}
+}
+
+// Dial connects to addr over network (e.g. "tcp") for the torrent
+// identified by infoHash, honoring the Dialer's Mode. The returned
+// net.Conn is ready for the plaintext BitTorrent handshake: if an MSE
+// handshake was negotiated, reads and writes are transparently
+// RC4-encrypted underneath.
+func (d *Dialer) Dial(network, addr string, infoHash []byte) (net.Conn, error) {
+	switch d.Mode {
+	case Plaintext:
+		return d.dialPlaintext(network, addr)
+
+	case ForceEncrypted:
+		return d.dialEncrypted(network, addr, infoHash)
+
+	case PreferEncrypted:
+		conn, err := d.dialEncrypted(network, addr, infoHash)
+		if err == nil {
+			return conn, nil
+		}
+		if de, ok := err.(*DialError); ok && de.Unreachable {
+			return nil, err
+		}
+		return d.dialPlaintext(network, addr)
+
+	default:
+		return nil, fmt.Errorf("mse: unknown dial mode %d", d.Mode)
+	}
+}
+
+func (d *Dialer) dialPlaintext(network, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, dialTimeout)
+	if err != nil {
+		return nil, &DialError{Addr: addr, Unreachable: true, Err: err}
+	}
+	return conn, nil
+}
+
+// dialEncrypted performs the initiator side of the MSE handshake and, on
+// success, returns a net.Conn that transparently RC4-encrypts the
+// subsequent plaintext BitTorrent handshake and all traffic after it.
+func (d *Dialer) dialEncrypted(network, addr string, infoHash []byte) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, dialTimeout)
+	if err != nil {
+		return nil, &DialError{Addr: addr, Unreachable: true, Err: err}
+	}
+
+	encConn, err := negotiate(conn, infoHash)
+	if err != nil {
+		conn.Close()
+		return nil, &DialError{Addr: addr, Unreachable: false, Err: err}
+	}
+
+	return encConn, nil
+}
+
+// negotiate drives the initiator ("A") side of the MSE handshake over
+// conn and returns the resulting encrypted connection. The whole exchange
+// is bounded by negotiateTimeout so a peer that accepts the connection
+// and then stalls can't hang the dial forever.
+func negotiate(conn net.Conn, infoHash []byte) (net.Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(negotiateTimeout)); err != nil {
+		return nil, fmt.Errorf("setting negotiation deadline: %w", err)
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	keys, err := newDHKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("generating DH keypair: %w", err)
+	}
+
+	padA, err := randomPad()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(append([]byte{}, keys.public...), padA...)); err != nil {
+		return nil, fmt.Errorf("sending Ya: %w", err)
+	}
+
+	peerPublic := make([]byte, mseKeyLen)
+	if _, err := io.ReadFull(conn, peerPublic); err != nil {
+		return nil, fmt.Errorf("reading Yb: %w", err)
+	}
+
+	s := keys.sharedSecret(peerPublic)
+	skey := infoHash
+
+	req1 := mseHash("req1", s)
+	req2 := mseHash("req2", skey)
+	req3 := mseHash("req3", s)
+	req2x3 := xorBytes(req2, req3)
+
+	keyA := mseHash("keyA", s, skey)
+	keyB := mseHash("keyB", s, skey)
+
+	writeCipher, err := newSyncedRC4(keyA)
+	if err != nil {
+		return nil, fmt.Errorf("deriving keyA cipher: %w", err)
+	}
+	readCipher, err := newSyncedRC4(keyB)
+	if err != nil {
+		return nil, fmt.Errorf("deriving keyB cipher: %w", err)
+	}
+
+	padC, err := randomPad()
+	if err != nil {
+		return nil, err
+	}
+
+	var plain bytes.Buffer
+	plain.Write(make([]byte, 8)) // VC: 8 zero bytes
+	binary.Write(&plain, binary.BigEndian, cryptoRC4)
+	binary.Write(&plain, binary.BigEndian, uint16(len(padC)))
+	plain.Write(padC)
+	binary.Write(&plain, binary.BigEndian, uint16(0)) // len(IA): we send the BT handshake out-of-band, after negotiation
+	encrypted := make([]byte, plain.Len())
+	writeCipher.XORKeyStream(encrypted, plain.Bytes())
+
+	msg := append(append(append([]byte{}, req1...), req2x3...), encrypted...)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, fmt.Errorf("sending crypto negotiation: %w", err)
+	}
+
+	if err := syncOnVC(conn, readCipher); err != nil {
+		return nil, err
+	}
+
+	var selectAndPadLen [6]byte
+	if _, err := io.ReadFull(conn, selectAndPadLen[:]); err != nil {
+		return nil, fmt.Errorf("reading crypto_select: %w", err)
+	}
+	readCipher.XORKeyStream(selectAndPadLen[:], selectAndPadLen[:])
+	cryptoSelect := binary.BigEndian.Uint32(selectAndPadLen[0:4])
+	padDLen := binary.BigEndian.Uint16(selectAndPadLen[4:6])
+	if cryptoSelect&cryptoRC4 == 0 {
+		return nil, fmt.Errorf("peer selected unsupported crypto method %d", cryptoSelect)
+	}
+
+	padD := make([]byte, padDLen)
+	if _, err := io.ReadFull(conn, padD); err != nil {
+		return nil, fmt.Errorf("reading padD: %w", err)
+	}
+	readCipher.XORKeyStream(padD, padD)
+
+	return &Conn{Conn: conn, readCipher: readCipher, writeCipher: writeCipher}, nil
+}
+
+// syncOnVC consumes bytes from conn one at a time until it finds the
+// 8-byte zero VC marker as encrypted by cipher, or gives up after
+// maxPadLen bytes (the spec's upper bound on PadB). The padding bytes are
+// not run through cipher, so on return cipher is positioned just past VC.
+func syncOnVC(conn net.Conn, cipher *rc4.Cipher) error {
+	var want [8]byte
+	cipher.XORKeyStream(want[:], want[:])
+
+	var window [8]byte
+	for i := 0; i < maxPadLen+len(window); i++ {
+		var b [1]byte
+		if _, err := io.ReadFull(conn, b[:]); err != nil {
+			return fmt.Errorf("syncing on VC: %w", err)
+		}
+		copy(window[:len(window)-1], window[1:])
+		window[len(window)-1] = b[0]
+
+		if i >= len(window)-1 && window == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("syncing on VC: no marker found in first %d bytes", maxPadLen+len(window))
+}
+
+func randomPad() ([]byte, error) {
+	var n [1]byte
+	if _, err := rand.Read(n[:]); err != nil {
+		return nil, err
+	}
+	pad := make([]byte, int(n[0])%(maxPadLen+1))
+	if _, err := rand.Read(pad); err != nil {
+		return nil, err
+	}
+	return pad, nil
+}
+
+func xorBytes(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+// Conn wraps a net.Conn whose reads and writes are transparently
+// RC4-encrypted with the keys negotiated during the MSE handshake. All
+// other net.Conn methods (Close, deadlines, addresses) delegate to the
+// underlying connection.
+type Conn struct {
+	net.Conn
+	readCipher  *rc4.Cipher
+	writeCipher *rc4.Cipher
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.readCipher.XORKeyStream(p[:n], p[:n])
+	}
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	enc := make([]byte, len(p))
+	c.writeCipher.XORKeyStream(enc, p)
+	return c.Conn.Write(enc)
+}
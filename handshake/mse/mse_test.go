@@ -0,0 +1,233 @@
+package mse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestMSEPrimeIs768Bits(t *testing.T) {
+	if got, want := msePrime.BitLen(), 768; got != want {
+		t.Fatalf("msePrime.BitLen() = %d, want %d (must match mseKeyLen=%d bytes)", got, want, mseKeyLen)
+	}
+}
+
+func TestDHKeyPairPublicFitsMSEKeyLen(t *testing.T) {
+	// Regression test: Y = G^X mod P must always fit in mseKeyLen bytes
+	// for every random X, or FillBytes panics. Run enough iterations that
+	// a prime/key-length mismatch (wrong bit size) can't pass by chance.
+	for i := 0; i < 50; i++ {
+		kp, err := newDHKeyPair()
+		if err != nil {
+			t.Fatalf("newDHKeyPair: %v", err)
+		}
+		if len(kp.public) != mseKeyLen {
+			t.Fatalf("public key length = %d, want %d", len(kp.public), mseKeyLen)
+		}
+
+		other, err := newDHKeyPair()
+		if err != nil {
+			t.Fatalf("newDHKeyPair: %v", err)
+		}
+		if len(kp.sharedSecret(other.public)) != mseKeyLen {
+			t.Fatalf("shared secret length = %d, want %d", len(kp.sharedSecret(other.public)), mseKeyLen)
+		}
+	}
+}
+
+// TestNegotiateRoundTrip drives the real initiator (negotiate) against an
+// independent, from-spec responder implementation over a loopback TCP
+// connection, then proves the resulting encrypted Conns can actually talk
+// in both directions.
+func TestNegotiateRoundTrip(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	infoHash := bytes.Repeat([]byte{0x42}, 20)
+
+	serverResult := make(chan *Conn, 1)
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		enc, err := respondMSE(conn, infoHash)
+		if err != nil {
+			conn.Close()
+			serverErr <- err
+			return
+		}
+		serverResult <- enc
+		serverErr <- nil
+	}()
+
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	encClient, err := negotiate(clientConn, infoHash)
+	if err != nil {
+		t.Fatalf("negotiate: %v", err)
+	}
+	defer encClient.Close()
+
+	if err := <-serverErr; err != nil {
+		t.Fatalf("responder: %v", err)
+	}
+	encServer := <-serverResult
+	defer encServer.Close()
+
+	msg := []byte("hello from initiator")
+	if _, err := encClient.Write(msg); err != nil {
+		t.Fatalf("client write: %v", err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(encServer, got); err != nil {
+		t.Fatalf("server read: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("server got %q, want %q", got, msg)
+	}
+
+	reply := []byte("hello from responder")
+	if _, err := encServer.Write(reply); err != nil {
+		t.Fatalf("server write: %v", err)
+	}
+	gotReply := make([]byte, len(reply))
+	if _, err := io.ReadFull(encClient, gotReply); err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if !bytes.Equal(gotReply, reply) {
+		t.Errorf("client got %q, want %q", gotReply, reply)
+	}
+}
+
+// respondMSE performs the responder ("B") side of the MSE handshake over
+// conn, independent of negotiate()'s implementation, so a round-trip test
+// against it actually exercises the wire format rather than just
+// reflecting negotiate()'s own assumptions back at itself.
+func respondMSE(conn net.Conn, infoHash []byte) (*Conn, error) {
+	keys, err := newDHKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	peerPublic := make([]byte, mseKeyLen)
+	if _, err := io.ReadFull(conn, peerPublic); err != nil {
+		return nil, fmt.Errorf("reading Ya: %w", err)
+	}
+
+	if _, err := conn.Write(keys.public); err != nil {
+		return nil, fmt.Errorf("sending Yb: %w", err)
+	}
+
+	s := keys.sharedSecret(peerPublic)
+	skey := infoHash
+
+	// A's Ya is followed by PadA of unknown length, so locate req1 with a
+	// sliding-window search rather than assuming it starts immediately.
+	wantReq1 := mseHash("req1", s)
+	if err := syncOnMarker(conn, wantReq1); err != nil {
+		return nil, fmt.Errorf("syncing on req1: %w", err)
+	}
+
+	req2 := mseHash("req2", skey)
+	req3 := mseHash("req3", s)
+	wantReq2x3 := xorBytes(req2, req3)
+	req2x3 := make([]byte, len(wantReq2x3))
+	if _, err := io.ReadFull(conn, req2x3); err != nil {
+		return nil, fmt.Errorf("reading req2x3: %w", err)
+	}
+	if !bytes.Equal(req2x3, wantReq2x3) {
+		return nil, fmt.Errorf("req2x3 mismatch (wrong SKEY?)")
+	}
+
+	keyA := mseHash("keyA", s, skey)
+	keyB := mseHash("keyB", s, skey)
+
+	readCipher, err := newSyncedRC4(keyA) // decrypts what the initiator encrypted with keyA
+	if err != nil {
+		return nil, err
+	}
+	writeCipher, err := newSyncedRC4(keyB) // encrypts what we send; initiator decrypts with keyB
+	if err != nil {
+		return nil, err
+	}
+
+	var header [12]byte // VC (8 bytes) + crypto_provide (4 bytes)
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, fmt.Errorf("reading VC+crypto_provide: %w", err)
+	}
+	readCipher.XORKeyStream(header[:], header[:])
+	if !bytes.Equal(header[:8], make([]byte, 8)) {
+		return nil, fmt.Errorf("bad VC: %x", header[:8])
+	}
+	if cryptoProvide := binary.BigEndian.Uint32(header[8:12]); cryptoProvide&cryptoRC4 == 0 {
+		return nil, fmt.Errorf("initiator did not offer RC4: %#x", cryptoProvide)
+	}
+
+	var padCLenBytes [2]byte
+	if _, err := io.ReadFull(conn, padCLenBytes[:]); err != nil {
+		return nil, fmt.Errorf("reading len(PadC): %w", err)
+	}
+	readCipher.XORKeyStream(padCLenBytes[:], padCLenBytes[:])
+	padC := make([]byte, binary.BigEndian.Uint16(padCLenBytes[:]))
+	if _, err := io.ReadFull(conn, padC); err != nil {
+		return nil, fmt.Errorf("reading PadC: %w", err)
+	}
+	readCipher.XORKeyStream(padC, padC)
+
+	var iaLenBytes [2]byte
+	if _, err := io.ReadFull(conn, iaLenBytes[:]); err != nil {
+		return nil, fmt.Errorf("reading len(IA): %w", err)
+	}
+	readCipher.XORKeyStream(iaLenBytes[:], iaLenBytes[:])
+	if iaLen := binary.BigEndian.Uint16(iaLenBytes[:]); iaLen > 0 {
+		ia := make([]byte, iaLen)
+		if _, err := io.ReadFull(conn, ia); err != nil {
+			return nil, fmt.Errorf("reading IA: %w", err)
+		}
+		readCipher.XORKeyStream(ia, ia)
+	}
+
+	var reply bytes.Buffer
+	reply.Write(make([]byte, 8)) // VC
+	binary.Write(&reply, binary.BigEndian, cryptoRC4)
+	binary.Write(&reply, binary.BigEndian, uint16(0)) // len(PadD): none
+	encReply := make([]byte, reply.Len())
+	writeCipher.XORKeyStream(encReply, reply.Bytes())
+	if _, err := conn.Write(encReply); err != nil {
+		return nil, fmt.Errorf("sending crypto_select reply: %w", err)
+	}
+
+	return &Conn{Conn: conn, readCipher: readCipher, writeCipher: writeCipher}, nil
+}
+
+// syncOnMarker consumes bytes from conn one at a time until the trailing
+// window matches want or maxPadLen bytes of leading padding are
+// exhausted, mirroring syncOnVC's search for an unpadded marker.
+func syncOnMarker(conn net.Conn, want []byte) error {
+	window := make([]byte, len(want))
+	for i := 0; i < maxPadLen+len(want); i++ {
+		var b [1]byte
+		if _, err := io.ReadFull(conn, b[:]); err != nil {
+			return err
+		}
+		copy(window, window[1:])
+		window[len(window)-1] = b[0]
+
+		if i >= len(want)-1 && bytes.Equal(window, want) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no marker found in first %d bytes", maxPadLen+len(want))
+}